@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var adminAddrFlag = flag.String("admin-addr", ":50054", "address for the admin HTTP API (subdomains management and metrics)")
+
+type subdomainRequest struct {
+	Name string `json:"name"`
+}
+
+// startAdminServer はsubdomainsの管理とPrometheus形式の/metricsを提供するHTTPサーバーを起動する
+func startAdminServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subdomains", handleSubdomainsCollection)
+	mux.HandleFunc("/subdomains/reset", handleSubdomainsReset)
+	mux.HandleFunc("/subdomains/", handleSubdomainsItem)
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start admin server: ", err)
+		}
+	}()
+	return server
+}
+
+// mutableBackendOrError はアクティブなBackendがMutableBackendを実装していなければ501を返し、falseを返す
+// subdomainsの追加/削除/リセットはstaticBackend(デフォルト)のみの概念であり、
+// -zone-file/-zone-configを使っている間はこのAPIでの変更は意味を持たない
+func mutableBackendOrError(w http.ResponseWriter) (MutableBackend, bool) {
+	mb, ok := currentBackend().(MutableBackend)
+	if !ok {
+		http.Error(w, "active backend does not support subdomain mutation (only the default static backend does)", http.StatusNotImplemented)
+		return nil, false
+	}
+	return mb, true
+}
+
+func handleSubdomainsCollection(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		muSubdomains.RLock()
+		list := append([]string(nil), subdomains...)
+		muSubdomains.RUnlock()
+		sort.Strings(list)
+		json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		mb, ok := mutableBackendOrError(w)
+		if !ok {
+			return
+		}
+		var req subdomainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := mb.AddName(dns.Fqdn(req.Name)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleSubdomainsItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mb, ok := mutableBackendOrError(w)
+	if !ok {
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/subdomains/")
+	if name == "" {
+		http.Error(w, "missing subdomain name", http.StatusBadRequest)
+		return
+	}
+	if err := mb.RemoveName(dns.Fqdn(name)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleSubdomainsReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mb, ok := mutableBackendOrError(w)
+	if !ok {
+		return
+	}
+	if err := mb.ResetNames(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsState.writeTo(w)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	zoneFileFlag   = flag.String("zone-file", "", "path to an RFC 1035 zone file to serve as the backend")
+	zoneConfigFlag = flag.String("zone-config", "", "path to a JSON/YAML zone config file to serve as the backend")
+)
+
+// ErrNXDOMAIN はqnameそのものがゾーンに存在しないことを表す
+// qnameは存在するがqtypeのレコードがない場合(NODATA)は、エラーを返さず空のスライスを返すこと
+var ErrNXDOMAIN = errors.New("nxdomain")
+
+// Backend はDNSクエリに対する回答の検索を抽象化するインターフェース
+// 実装は静的な設定、ゾーンファイル、JSON/YAML設定など問わない
+type Backend interface {
+	// Lookup はqnameとqtypeに一致するリソースレコードを返す
+	// qname自体が存在しない場合はErrNXDOMAINを返す
+	Lookup(qname string, qtype uint16) ([]dns.RR, error)
+	// SOA はNXDOMAIN/NODATA応答の権威セクションに載せるSOAレコードを返す(なければnil)
+	SOA(qname string) dns.RR
+}
+
+// ECSAwareBackend はEDNS0 Client Subnetのクライアントアドレスに応じて
+// 回答を出し分けられるBackendの拡張インターフェース
+type ECSAwareBackend interface {
+	Backend
+	// LookupECS はclientIPに応じた回答と、それに使ったSCOPE PREFIX-LENGTHを返す
+	LookupECS(qname string, qtype uint16, clientIP net.IP) (rrs []dns.RR, scopePrefix uint8, err error)
+}
+
+// MutableBackend は管理API(admin.go)からのサブドメイン追加/削除/リセットをサポートするBackendの拡張
+// zone-file/zone-configなど静的でないBackendはこれを実装しない。サービス中の設定ファイルや
+// ゾーンファイルそのものを書き換えるのは管理APIの責務ではないため
+type MutableBackend interface {
+	Backend
+	AddName(name string) error
+	RemoveName(name string) error
+	ResetNames() error
+}
+
+// cnameFallback は要求されたqtypeのレコードがゾーン中に見当たらない場合にCNAMEレコードを返す
+// (CNAMEチェインの解決自体はフォワーダ/クライアント側に委ねる、標準的なDNSの挙動)
+func cnameFallback(byType map[uint16][]dns.RR, qtype uint16) []dns.RR {
+	if qtype == dns.TypeCNAME {
+		return nil
+	}
+	return byType[dns.TypeCNAME]
+}
+
+var (
+	activeBackend   Backend = newStaticBackend()
+	muActiveBackend         = sync.RWMutex{}
+)
+
+// currentBackend はhandleが使う現在のBackendを返す
+func currentBackend() Backend {
+	muActiveBackend.RLock()
+	defer muActiveBackend.RUnlock()
+	return activeBackend
+}
+
+// setBackend はBackendを入れ替える
+// 既に処理中のクエリはcurrentBackend()で取得した古いBackendをそのまま使い続けるため、
+// 切り替え中でもクエリを取りこぼさない
+func setBackend(b Backend) {
+	muActiveBackend.Lock()
+	defer muActiveBackend.Unlock()
+	activeBackend = b
+}
+
+// reloadBackend はフラグで指定されたソースからBackendを読み直し、アクティブなBackendと入れ替える
+// -zone-file/-zone-configのどちらも指定されなければ、これまで通りstaticBackendを使う
+func reloadBackend() {
+	var (
+		b   Backend
+		err error
+	)
+	switch {
+	case *zoneFileFlag != "":
+		b, err = loadFileBackend(*zoneFileFlag)
+	case *zoneConfigFlag != "":
+		b, err = loadConfigBackend(*zoneConfigFlag)
+	default:
+		b = newStaticBackend()
+	}
+	if err != nil {
+		log.Printf("failed to reload backend: %v", err)
+		return
+	}
+	setBackend(b)
+	log.Printf("backend reloaded")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCnameFallbackReturnsCNAMEForOtherQtypes(t *testing.T) {
+	byType := map[uint16][]dns.RR{
+		dns.TypeCNAME: {newRR("www.t.example.com. 5 IN CNAME target.t.example.com.")},
+	}
+	rrs := cnameFallback(byType, dns.TypeA)
+	if len(rrs) != 1 || rrs[0].Header().Rrtype != dns.TypeCNAME {
+		t.Fatalf("cnameFallback(A) = %v, want the CNAME record", rrs)
+	}
+}
+
+func TestCnameFallbackDoesNotRecurseForCNAMEQueries(t *testing.T) {
+	byType := map[uint16][]dns.RR{
+		dns.TypeCNAME: {newRR("www.t.example.com. 5 IN CNAME target.t.example.com.")},
+	}
+	if rrs := cnameFallback(byType, dns.TypeCNAME); rrs != nil {
+		t.Fatalf("cnameFallback(CNAME) = %v, want nil", rrs)
+	}
+}
+
+// backendFixtures builds the zone
+//
+//	only-a.t.example.com.    A     192.168.0.11
+//	only-cname.t.example.com. CNAME target.t.example.com.
+//
+// against both the file and config backend record layouts, since they share the same
+// NXDOMAIN/NODATA/CNAME-fallback semantics in Lookup.
+func backendFixtures(t *testing.T) map[string]Backend {
+	t.Helper()
+	records := map[string]map[uint16][]dns.RR{
+		"only-a.t.example.com.": {
+			dns.TypeA: {newRR("only-a.t.example.com. 5 IN A 192.168.0.11")},
+		},
+		"only-cname.t.example.com.": {
+			dns.TypeCNAME: {newRR("only-cname.t.example.com. 5 IN CNAME target.t.example.com.")},
+		},
+	}
+	return map[string]Backend{
+		"fileBackend":   &fileBackend{records: records},
+		"configBackend": &configBackend{records: records},
+	}
+}
+
+func TestBackendLookupNXDOMAINForUnknownName(t *testing.T) {
+	for name, b := range backendFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			rrs, err := b.Lookup("does-not-exist.t.example.com.", dns.TypeA)
+			if !errors.Is(err, ErrNXDOMAIN) {
+				t.Fatalf("Lookup() err = %v, want ErrNXDOMAIN", err)
+			}
+			if rrs != nil {
+				t.Fatalf("Lookup() rrs = %v, want nil", rrs)
+			}
+		})
+	}
+}
+
+func TestBackendLookupNODATAForKnownNameWrongQtype(t *testing.T) {
+	for name, b := range backendFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			rrs, err := b.Lookup("only-a.t.example.com.", dns.TypeAAAA)
+			if err != nil {
+				t.Fatalf("Lookup() err = %v, want nil (NODATA, not NXDOMAIN)", err)
+			}
+			if len(rrs) != 0 {
+				t.Fatalf("Lookup() rrs = %v, want empty", rrs)
+			}
+		})
+	}
+}
+
+func TestBackendLookupFallsBackToCNAME(t *testing.T) {
+	for name, b := range backendFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			rrs, err := b.Lookup("only-cname.t.example.com.", dns.TypeA)
+			if err != nil {
+				t.Fatalf("Lookup() err = %v, want nil", err)
+			}
+			if len(rrs) != 1 || rrs[0].Header().Rrtype != dns.TypeCNAME {
+				t.Fatalf("Lookup() rrs = %v, want the CNAME record", rrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// recordConfig はJSON/YAMLゾーン設定ファイル中の1レコードを表す
+// ValueにはRRのrdata部分をゾーンファイル構文のまま書く
+// (MXなら"10 mail.example.com."、SRVなら"10 20 5060 sip.example.com."のように)
+type recordConfig struct {
+	Name  string `json:"name" yaml:"name"`
+	Type  string `json:"type" yaml:"type"`
+	TTL   uint32 `json:"ttl" yaml:"ttl"`
+	Value string `json:"value" yaml:"value"`
+}
+
+type zoneConfig struct {
+	Records []recordConfig `json:"records" yaml:"records"`
+}
+
+// configBackend はA/AAAA/CNAME/MX/NS/SRV/TXTレコードをJSON/YAMLファイルから読み込んで提供するBackend
+type configBackend struct {
+	records map[string]map[uint16][]dns.RR
+	soa     dns.RR
+}
+
+// loadConfigBackend はpathの拡張子(.json/.yaml/.yml)に応じてzoneConfigを読み込みconfigBackendを構築する
+func loadConfigBackend(path string) (*configBackend, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg zoneConfig
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(data, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		return nil, fmt.Errorf("configbackend: unsupported extension %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := &configBackend{records: make(map[string]map[uint16][]dns.RR)}
+	for _, rec := range cfg.Records {
+		qtype, ok := dns.StringToType[strings.ToUpper(rec.Type)]
+		if !ok {
+			return nil, fmt.Errorf("configbackend: unknown record type %q for %q", rec.Type, rec.Name)
+		}
+
+		name := dns.Fqdn(rec.Name)
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, rec.TTL, strings.ToUpper(rec.Type), rec.Value))
+		if err != nil {
+			return nil, fmt.Errorf("configbackend: parsing record %q: %w", rec.Name, err)
+		}
+		if qtype == dns.TypeSOA {
+			b.soa = rr
+		}
+
+		name = strings.ToLower(name)
+		if b.records[name] == nil {
+			b.records[name] = make(map[uint16][]dns.RR)
+		}
+		b.records[name][qtype] = append(b.records[name][qtype], rr)
+	}
+	return b, nil
+}
+
+func (b *configBackend) Lookup(qname string, qtype uint16) ([]dns.RR, error) {
+	byType, ok := b.records[strings.ToLower(qname)]
+	if !ok {
+		return nil, ErrNXDOMAIN
+	}
+	if rrs := byType[qtype]; len(rrs) > 0 {
+		return rrs, nil
+	}
+	return cnameFallback(byType, qtype), nil
+}
+
+func (b *configBackend) SOA(qname string) dns.RR {
+	return b.soa
+}
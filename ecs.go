@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// parseECS はクエリのOPTレコードからEDNS0 Client Subnet (RFC 7871) オプションを取り出す
+// オプションが付与されていない場合はok=falseを返す
+func parseECS(r *dns.Msg) (clientIP net.IP, sourcePrefix uint8, family uint16, ok bool) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil, 0, 0, false
+	}
+	for _, o := range opt.Option {
+		sub, isECS := o.(*dns.EDNS0_SUBNET)
+		if !isECS {
+			continue
+		}
+		return sub.Address, sub.SourceNetmask, sub.Family, true
+	}
+	return nil, 0, 0, false
+}
+
+// appendECS はレスポンスにEDNS0 Client Subnetオプションを付与する
+// SCOPE PREFIX-LENGTHには実際にマッチしたレコードのプレフィックス長を反映する
+func appendECS(m *dns.Msg, clientIP net.IP, family uint16, sourcePrefix, scopePrefix uint8) {
+	m.SetEdns0(4096, false)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: sourcePrefix,
+		SourceScope:   scopePrefix,
+		Address:       clientIP,
+	})
+}
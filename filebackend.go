@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// fileBackend はRFC 1035ゾーンファイルをdns.NewZoneParserで読み込んで提供するBackend
+type fileBackend struct {
+	records map[string]map[uint16][]dns.RR
+	soa     dns.RR
+}
+
+// loadFileBackend はpathのゾーンファイルを読み込みfileBackendを構築する
+func loadFileBackend(path string) (*fileBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &fileBackend{records: make(map[string]map[uint16][]dns.RR)}
+	zp := dns.NewZoneParser(f, "", path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			b.soa = rr
+		}
+		name := strings.ToLower(rr.Header().Name)
+		if b.records[name] == nil {
+			b.records[name] = make(map[uint16][]dns.RR)
+		}
+		b.records[name][rr.Header().Rrtype] = append(b.records[name][rr.Header().Rrtype], rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *fileBackend) Lookup(qname string, qtype uint16) ([]dns.RR, error) {
+	byType, ok := b.records[strings.ToLower(qname)]
+	if !ok {
+		return nil, ErrNXDOMAIN
+	}
+	if rrs := byType[qtype]; len(rrs) > 0 {
+		return rrs, nil
+	}
+	return cnameFallback(byType, qtype), nil
+}
+
+func (b *fileBackend) SOA(qname string) dns.RR {
+	return b.soa
+}
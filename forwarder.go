@@ -0,0 +1,211 @@
+package main
+
+import (
+	"container/list"
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	upstreamFlag       = flag.String("upstream", "", "comma separated list of upstream DNS resolvers (host:port) to forward unmatched queries to")
+	cacheSizeFlag      = flag.Int("cache-size", 10000, "maximum number of forwarded responses kept in the LRU cache")
+	forwardTimeoutFlag = flag.Duration("forward-timeout", 2*time.Second, "timeout for each upstream forwarding attempt")
+	dropUnmatchedFlag  = flag.Bool("drop-unmatched", false, "drop queries outside subdomains instead of forwarding them upstream (water-torture behavior, opt-in)")
+
+	upstreams      []string
+	forwardTimeout time.Duration
+	fwdCache       *lruCache
+)
+
+// initForwarder はフラグの値からforwardQueryが使う設定を組み立てる
+func initForwarder() {
+	forwardTimeout = *forwardTimeoutFlag
+	fwdCache = newLRUCache(*cacheSizeFlag)
+
+	upstreams = nil
+	for _, u := range strings.Split(*upstreamFlag, ",") {
+		u = strings.TrimSpace(u)
+		if u != "" {
+			upstreams = append(upstreams, u)
+		}
+	}
+}
+
+type cacheKey struct {
+	qname  string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	msg     *dns.Msg
+	expires time.Time
+}
+
+type lruItem struct {
+	key   cacheKey
+	entry cacheEntry
+}
+
+// lruCache はフォワード先から得たレスポンスを(qname, qtype, qclass)単位でTTLに従いキャッシュする
+// 容量を超えた場合は最も使われていないエントリから追い出す(LRU)
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[cacheKey]*list.Element
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key cacheKey) (*dns.Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*lruItem)
+	if time.Now().After(item.entry.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return item.entry.msg.Copy(), true
+}
+
+// purge はキャッシュの中身をすべて消す(subdomains変更時など、古いレスポンスを残さないために使う)
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[cacheKey]*list.Element)
+}
+
+func (c *lruCache) set(key cacheKey, msg *dns.Msg, ttl time.Duration) {
+	if c.capacity <= 0 || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruItem).entry = cacheEntry{msg: msg.Copy(), expires: time.Now().Add(ttl)}
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&lruItem{key: key, entry: cacheEntry{msg: msg.Copy(), expires: time.Now().Add(ttl)}})
+	c.items[key] = el
+
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+	}
+}
+
+// minTTL はメッセージ中のレコードのうち最小のTTLを返す(正のキャッシュ期間の決定に使う)
+func minTTL(rrs []dns.RR) uint32 {
+	var min uint32
+	first := true
+	for _, rr := range rrs {
+		ttl := rr.Header().Ttl
+		if first || ttl < min {
+			min = ttl
+			first = false
+		}
+	}
+	return min
+}
+
+// negativeTTL はSOAのMINIMUMフィールドから、NXDOMAIN/NODATA応答のネガティブキャッシュTTLを求める(RFC 2308)
+func negativeTTL(m *dns.Msg) uint32 {
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Minttl
+		}
+	}
+	return 0
+}
+
+// forwardQuery はrを設定済みのupstream群へ並行に問い合わせ、最初に成功したレスポンスを返す
+// (qname, qtype, qclass)でキャッシュされたレスポンスがあればそれを優先して使う
+func forwardQuery(r *dns.Msg) (*dns.Msg, error) {
+	key := cacheKey{qname: r.Question[0].Name, qtype: r.Question[0].Qtype, qclass: r.Question[0].Qclass}
+	if cached, ok := fwdCache.get(key); ok {
+		cached.Id = r.Id
+		return cached, nil
+	}
+
+	type result struct {
+		msg *dns.Msg
+		err error
+	}
+	resultCh := make(chan result, len(upstreams))
+	for _, upstream := range upstreams {
+		upstream := upstream
+		go func() {
+			msg, err := queryUpstream(upstream, r)
+			resultCh <- result{msg: msg, err: err}
+		}()
+	}
+
+	var lastErr error
+	for range upstreams {
+		res := <-resultCh
+		if res.err != nil {
+			lastErr = res.err
+			continue
+		}
+		cacheForwardedResponse(key, res.msg)
+		return res.msg, nil
+	}
+	return nil, lastErr
+}
+
+// queryUpstream は1台のupstreamへUDPで問い合わせ、TC=1が返った場合はTCPでフォールバックする
+func queryUpstream(upstream string, r *dns.Msg) (*dns.Msg, error) {
+	udpClient := &dns.Client{Net: "udp", Timeout: forwardTimeout}
+	msg, _, err := udpClient.Exchange(r, upstream)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Truncated {
+		tcpClient := &dns.Client{Net: "tcp", Timeout: forwardTimeout}
+		msg, _, err = tcpClient.Exchange(r, upstream)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// cacheForwardedResponse はフォワード結果をレコードのTTL、あるいはNXDOMAIN/NODATAの場合は
+// SOAのMINIMUMフィールドに基づきネガティブキャッシュする
+func cacheForwardedResponse(key cacheKey, msg *dns.Msg) {
+	if msg.Rcode == dns.RcodeNameError || (msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0) {
+		if ttl := negativeTTL(msg); ttl > 0 {
+			fwdCache.set(key, msg, time.Duration(ttl)*time.Second)
+		}
+		return
+	}
+	if len(msg.Answer) > 0 {
+		fwdCache.set(key, msg, time.Duration(minTTL(msg.Answer))*time.Second)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLRUCacheGetSetRoundtrip(t *testing.T) {
+	c := newLRUCache(10)
+	key := cacheKey{qname: "www.t.example.com.", qtype: dns.TypeA, qclass: dns.ClassINET}
+	m := new(dns.Msg)
+	m.SetQuestion(key.qname, key.qtype)
+	m.Answer = []dns.RR{newRR("www.t.example.com. 300 IN A 192.168.0.11")}
+
+	c.set(key, m, 300*time.Second)
+
+	got, ok := c.get(key)
+	if !ok {
+		t.Fatal("expected cache hit after set")
+	}
+	if len(got.Answer) != 1 || got.Answer[0].String() != m.Answer[0].String() {
+		t.Fatalf("got = %v, want a copy of %v", got.Answer, m.Answer)
+	}
+}
+
+func TestLRUCacheExpiresAfterTTL(t *testing.T) {
+	c := newLRUCache(10)
+	key := cacheKey{qname: "www.t.example.com.", qtype: dns.TypeA, qclass: dns.ClassINET}
+	m := new(dns.Msg)
+	m.SetQuestion(key.qname, key.qtype)
+
+	c.set(key, m, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := c.get(key); ok {
+		t.Fatal("expected cache miss once the TTL has elapsed")
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(2)
+	keyA := cacheKey{qname: "a.t.example.com.", qtype: dns.TypeA, qclass: dns.ClassINET}
+	keyB := cacheKey{qname: "b.t.example.com.", qtype: dns.TypeA, qclass: dns.ClassINET}
+	keyC := cacheKey{qname: "c.t.example.com.", qtype: dns.TypeA, qclass: dns.ClassINET}
+	m := new(dns.Msg)
+
+	c.set(keyA, m, time.Minute)
+	c.set(keyB, m, time.Minute)
+	// touch A so B becomes the least recently used entry
+	if _, ok := c.get(keyA); !ok {
+		t.Fatal("expected cache hit for A before C is inserted")
+	}
+	c.set(keyC, m, time.Minute)
+
+	if _, ok := c.get(keyB); ok {
+		t.Fatal("expected B to be evicted as the least recently used entry")
+	}
+	if _, ok := c.get(keyA); !ok {
+		t.Fatal("expected A to survive eviction since it was used most recently")
+	}
+	if _, ok := c.get(keyC); !ok {
+		t.Fatal("expected C to survive eviction since it was just inserted")
+	}
+}
+
+func TestMinTTLReturnsSmallestRecordTTL(t *testing.T) {
+	rrs := []dns.RR{
+		newRR("www.t.example.com. 300 IN A 192.168.0.11"),
+		newRR("www.t.example.com. 60 IN A 192.168.0.12"),
+		newRR("www.t.example.com. 120 IN A 192.168.0.13"),
+	}
+	if got := minTTL(rrs); got != 60 {
+		t.Fatalf("minTTL = %d, want 60", got)
+	}
+}
+
+func TestNegativeTTLReadsSOAMinimum(t *testing.T) {
+	m := new(dns.Msg)
+	m.Ns = []dns.RR{newRR("t.example.com. 5 IN SOA ns1.t.example.com. admin.t.example.com. 1 7200 3600 1209600 42")}
+	if got := negativeTTL(m); got != 42 {
+		t.Fatalf("negativeTTL = %d, want 42", got)
+	}
+}
+
+func TestNegativeTTLWithoutSOAIsZero(t *testing.T) {
+	m := new(dns.Msg)
+	if got := negativeTTL(m); got != 0 {
+		t.Fatalf("negativeTTL = %d, want 0", got)
+	}
+}
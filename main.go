@@ -1,13 +1,15 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"slices"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -45,35 +47,83 @@ func newRR(s string) dns.RR {
 // w: DNSレスポンスを書き込みするためのインターフェース
 // r: 受信したDNSクエリメッセージ
 func handle(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	q := r.Question[0]
+	responded := false
+	defer func() {
+		metricsState.recordQuery(dns.TypeToString[q.Qtype], responded, remoteIP(w), time.Since(start))
+	}()
+	write := func(m *dns.Msg) {
+		if !rrl.allow(remoteIP(w), q.Name, rcodeClass(m.Rcode, len(m.Answer) > 0)) {
+			metricsState.recordRateLimited()
+			if !shouldSlip() {
+				// RRLによる無応答。水責め対策と同じく完全にドロップする
+				return
+			}
+			tc := new(dns.Msg)
+			tc.SetReply(r)
+			tc.Truncated = true
+			responded = true
+			w.WriteMsg(tc)
+			return
+		}
+		responded = true
+		w.WriteMsg(m)
+	}
+
 	// レスポンスの準備
 	// 新しいDNSメッセージを作成し、受信したクエリに対する返信として設定
 	m := new(dns.Msg)
 	m.SetReply(r)
 
-	// "t.example.com." ドメインに対するNSクエリの場合、特定のNSレコードとAレコードを返す
-	// NSクエリの発行は、dig @*.*.*.* -p 50053 t.example.com NS +short
-	if r.Question[0].Qtype == dns.TypeNS && r.Question[0].Name == "t.example.com." {
-		m.Answer = []dns.RR{
-			newRR("t.example.com. 5 IN NS ns1.t.example.com."),
-		}
-		m.Extra = []dns.RR{
-			newRR("ns1.t.example.com. 5 IN A 192.168.0.11"),
-		}
+	backend := currentBackend()
+	clientIP, sourcePrefix, family, hasECS := parseECS(r)
+
+	var (
+		rrs         []dns.RR
+		scopePrefix uint8
+		err         error
+	)
+	if ecsBackend, ok := backend.(ECSAwareBackend); ok && hasECS {
+		rrs, scopePrefix, err = ecsBackend.LookupECS(q.Name, q.Qtype, clientIP)
 	} else {
-		muSubdomains.RLock()
-		defer muSubdomains.RUnlock()
+		rrs, err = backend.Lookup(q.Name, q.Qtype)
+	}
 
-		// subdomainsに含まれているならば、Aレコードを返す
-		if slices.Contains(subdomains, r.Question[0].Name) {
-			m.Answer = []dns.RR{
-				newRR(r.Question[0].Name + " 5 IN A 192.168.0.11"),
+	switch {
+	case errors.Is(err, ErrNXDOMAIN):
+		// backendに存在しない名前は、フォワード設定があればupstreamへ問い合わせる
+		if len(upstreams) > 0 && !*dropUnmatchedFlag {
+			if resp, fwdErr := forwardQuery(r); fwdErr == nil {
+				resp.Id = r.Id
+				write(resp)
+				return
 			}
-		} else {
-			// ここを返さないことで、水責めに対して
+		}
+		if *dropUnmatchedFlag {
+			// ここを返さないことで、水責めに対して応答しない(-drop-unmatchedによるopt-in)
 			return
 		}
+		m.Rcode = dns.RcodeNameError
+		if soa := backend.SOA(q.Name); soa != nil {
+			m.Ns = []dns.RR{soa}
+		}
+	case err != nil:
+		return
+	case len(rrs) == 0:
+		// NODATA: 名前は存在するがこのqtypeのレコードがないのでSOAを権威セクションに返す
+		if soa := backend.SOA(q.Name); soa != nil {
+			m.Ns = []dns.RR{soa}
+		}
+	default:
+		m.Answer = rrs
+		// 複数レコードがある場合は順序をシャッフルし、単純な負荷分散を行う
+		shuffleAnswers(m.Answer)
+		if hasECS {
+			appendECS(m, clientIP, family, sourcePrefix, scopePrefix)
+		}
 	}
-	w.WriteMsg(m)
+	write(m)
 }
 
 // 指定ネットワークでDNSサーバー処理を実行
@@ -84,13 +134,31 @@ func serveDNS(server *dns.Server) {
 }
 
 func main() {
+	flag.Parse()
 	resetSubdomains()
-	dns.HandleFunc("t.example.com.", handle)
+	initForwarder()
+	reloadBackend()
 
-	udpSrv := &dns.Server{Addr: ":50053", Net: "udp"}
-	defer udpSrv.Shutdown()
+	udpSrv := &dns.Server{Addr: ":50053", Net: "udp", Handler: handlerFor("udp")}
 	go serveDNS(udpSrv)
 
+	tcpSrv := serveTCP(*tcpAddrFlag)
+	dotSrv := serveDoT(*dotAddrFlag, *tlsCertFlag, *tlsKeyFlag)
+	dohSrv := serveDoH(*dohAddrFlag, *tlsCertFlag, *tlsKeyFlag)
+	adminSrv := startAdminServer(*adminAddrFlag)
+	if *rrlRPSFlag > 0 {
+		startRRLCleanup()
+	}
+
+	// SIGHUPを受信するたびにBackendをホットリロードする(処理中のクエリは古いBackendのまま完了する)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadBackend()
+		}
+	}()
+
 	fmt.Println("_________________________________________mydns")
 	fmt.Println(" __  __  __   __   ____   _   _   ____  ")
 	fmt.Println("|  \\/  | \\ \\ / /  |  _ \\ | \\ | | / ___| ")
@@ -99,9 +167,19 @@ func main() {
 	fmt.Println("|_|  |_|   |_|    |____/ |_| \\_| |____/ ")
 	fmt.Println("_________________________________________mydns")
 
-	// シグナルを受信したら終了
+	// シグナルを受信したら全リスナーをgraceful shutdownして終了
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	<-quit
+
+	udpSrv.Shutdown()
+	tcpSrv.Shutdown()
+	if dotSrv != nil {
+		dotSrv.Shutdown()
+	}
+	if dohSrv != nil {
+		shutdownHTTP(dohSrv)
+	}
+	shutdownHTTP(adminSrv)
 	fmt.Println("Hello-4")
 }
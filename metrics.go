@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// metrics はオペレーターが水責め対策の挙動などをリアルタイムに観測できるよう、
+// qtype別クエリ数、matched/dropped数、レスポンスレイテンシ、クライアントサブネット別クエリ数を集計する
+// クライアントは個別のIPではなくRRLと同じサブネット(-rrl-ipv4-prefix/-rrl-ipv6-prefix)単位で集計し、
+// 無数の送信元アドレスを使う水責め/リフレクション攻撃でこのマップ自体が無限に育たないようにする
+type metrics struct {
+	mu             sync.Mutex
+	queriesByQtype map[string]int64
+	matched        int64
+	dropped        int64
+	latencyBuckets []float64
+	latencyCounts  []int64
+	latencySum     float64
+	latencyCount   int64
+	clientQueries  map[string]int64
+	rateLimited    int64
+}
+
+var metricsState = newMetrics()
+
+func newMetrics() *metrics {
+	latencyBuckets := []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+	return &metrics{
+		queriesByQtype: make(map[string]int64),
+		latencyBuckets: latencyBuckets,
+		latencyCounts:  make([]int64, len(latencyBuckets)+1),
+		clientQueries:  make(map[string]int64),
+	}
+}
+
+// recordQuery は1クエリぶんの観測結果を集計に反映する
+func (m *metrics) recordQuery(qtype string, responded bool, clientIP net.IP, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queriesByQtype[qtype]++
+	if responded {
+		m.matched++
+	} else {
+		m.dropped++
+	}
+	m.clientQueries[subnetKey(clientIP)]++
+
+	seconds := latency.Seconds()
+	m.latencySum += seconds
+	m.latencyCount++
+	bucket := len(m.latencyBuckets)
+	for i, le := range m.latencyBuckets {
+		if seconds <= le {
+			bucket = i
+			break
+		}
+	}
+	m.latencyCounts[bucket]++
+}
+
+// recordRateLimited はRRLによってレスポンスが制限された(ドロップまたはTC=1へ切り詰められた)ことを記録する
+func (m *metrics) recordRateLimited() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rateLimited++
+}
+
+// writeTo はPrometheusのtext expositionフォーマットでメトリクスを書き出す
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP godns_queries_total Number of DNS queries received, by qtype.")
+	fmt.Fprintln(w, "# TYPE godns_queries_total counter")
+	qtypes := make([]string, 0, len(m.queriesByQtype))
+	for qtype := range m.queriesByQtype {
+		qtypes = append(qtypes, qtype)
+	}
+	sort.Strings(qtypes)
+	for _, qtype := range qtypes {
+		fmt.Fprintf(w, "godns_queries_total{qtype=%q} %d\n", qtype, m.queriesByQtype[qtype])
+	}
+
+	fmt.Fprintln(w, "# HELP godns_queries_matched_total Number of queries answered versus silently dropped.")
+	fmt.Fprintln(w, "# TYPE godns_queries_matched_total counter")
+	fmt.Fprintf(w, "godns_queries_matched_total{result=\"matched\"} %d\n", m.matched)
+	fmt.Fprintf(w, "godns_queries_matched_total{result=\"dropped\"} %d\n", m.dropped)
+
+	fmt.Fprintln(w, "# HELP godns_response_latency_seconds Response latency distribution.")
+	fmt.Fprintln(w, "# TYPE godns_response_latency_seconds histogram")
+	cumulative := int64(0)
+	for i, le := range m.latencyBuckets {
+		cumulative += m.latencyCounts[i]
+		fmt.Fprintf(w, "godns_response_latency_seconds_bucket{le=%q} %d\n", fmt.Sprintf("%g", le), cumulative)
+	}
+	cumulative += m.latencyCounts[len(m.latencyBuckets)]
+	fmt.Fprintf(w, "godns_response_latency_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "godns_response_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "godns_response_latency_seconds_count %d\n", m.latencyCount)
+
+	fmt.Fprintln(w, "# HELP godns_rate_limited_total Number of responses rate-limited by RRL (dropped or truncated).")
+	fmt.Fprintln(w, "# TYPE godns_rate_limited_total counter")
+	fmt.Fprintf(w, "godns_rate_limited_total %d\n", m.rateLimited)
+
+	fmt.Fprintln(w, "# HELP godns_client_subnet_queries_total Number of queries received, by client subnet.")
+	fmt.Fprintln(w, "# TYPE godns_client_subnet_queries_total counter")
+	subnets := make([]string, 0, len(m.clientQueries))
+	for subnet := range m.clientQueries {
+		subnets = append(subnets, subnet)
+	}
+	sort.Strings(subnets)
+	for _, subnet := range subnets {
+		fmt.Fprintf(w, "godns_client_subnet_queries_total{subnet=%q} %d\n", subnet, m.clientQueries[subnet])
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// subnetRecordSet はEDNS0 Client Subnetで指定されたクライアントの所属ネットワーク(CIDR)ごとに
+// 返すAレコードのIP群を切り替えるためのエントリ
+type subnetRecordSet struct {
+	cidr string
+	ips  []string
+}
+
+// defaultARecords は各サブドメインに対応するデフォルトのAレコード群(複数可)
+// クライアントのサブネットがsubnetARecordsのどのCIDRにも一致しない場合に使用される
+var defaultARecords = map[string][]string{
+	"t.example.com.":         {"192.168.0.11"},
+	"ns1.t.example.com.":     {"192.168.0.11"},
+	"www.t.example.com.":     {"192.168.0.11", "192.168.0.12", "192.168.0.13"},
+	"test001.t.example.com.": {"192.168.0.11"},
+}
+
+// defaultNSRecords はNSクエリに対して返すデフォルトの権威サーバー名
+var defaultNSRecords = map[string]string{
+	"t.example.com.": "ns1.t.example.com.",
+}
+
+// subnetARecords はクライアントサブネットに応じて返すAレコードを切り替えるためのテーブル
+// 複数のCIDRが一致する場合は最長プレフィックスマッチで一番具体的なものを採用する
+var subnetARecords = map[string][]subnetRecordSet{
+	"www.t.example.com.": {
+		{cidr: "192.168.1.0/24", ips: []string{"192.168.1.21"}},
+		{cidr: "192.168.0.0/16", ips: []string{"192.168.0.21"}},
+	},
+}
+
+// lookupARecords は名前とクライアントのサブネットから返すAレコードのIP群と
+// レスポンスのSCOPE PREFIX-LENGTHに使うプレフィックス長を決定する
+// clientIPがnilの場合やどのCIDRにも一致しない場合はデフォルトのレコード(scope 0)を返す
+func lookupARecords(name string, clientIP net.IP) (ips []string, scopePrefix uint8) {
+	if clientIP != nil {
+		bestOnes := -1
+		var bestIPs []string
+		for _, s := range subnetARecords[name] {
+			_, ipnet, err := net.ParseCIDR(s.cidr)
+			if err != nil || !ipnet.Contains(clientIP) {
+				continue
+			}
+			ones, _ := ipnet.Mask.Size()
+			if ones > bestOnes {
+				bestOnes = ones
+				bestIPs = s.ips
+			}
+		}
+		if bestOnes >= 0 {
+			return bestIPs, uint8(bestOnes)
+		}
+	}
+	return defaultARecords[name], 0
+}
+
+// shuffleAnswers は複数のAレコードが設定されている場合に順序をランダムに入れ替える
+// mesos-dnsのshuffleAnswersと同様、単純なラウンドロビン的負荷分散を実現するため
+func shuffleAnswers(rrs []dns.RR) {
+	rand.Shuffle(len(rrs), func(i, j int) {
+		rrs[i], rrs[j] = rrs[j], rrs[i]
+	})
+}
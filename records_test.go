@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLookupARecordsLongestPrefixMatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		qname      string
+		clientIP   net.IP
+		wantIPs    []string
+		wantPrefix uint8
+	}{
+		{
+			name:       "matches the more specific /24 over the /16",
+			qname:      "www.t.example.com.",
+			clientIP:   net.ParseIP("192.168.1.5"),
+			wantIPs:    []string{"192.168.1.21"},
+			wantPrefix: 24,
+		},
+		{
+			name:       "falls back to the /16 when the /24 does not match",
+			qname:      "www.t.example.com.",
+			clientIP:   net.ParseIP("192.168.5.5"),
+			wantIPs:    []string{"192.168.0.21"},
+			wantPrefix: 16,
+		},
+		{
+			name:       "falls back to the default records when no subnet matches",
+			qname:      "www.t.example.com.",
+			clientIP:   net.ParseIP("10.0.0.1"),
+			wantIPs:    defaultARecords["www.t.example.com."],
+			wantPrefix: 0,
+		},
+		{
+			name:       "falls back to the default records when clientIP is nil",
+			qname:      "www.t.example.com.",
+			clientIP:   nil,
+			wantIPs:    defaultARecords["www.t.example.com."],
+			wantPrefix: 0,
+		},
+		{
+			name:       "name with no subnet table uses the default records",
+			qname:      "test001.t.example.com.",
+			clientIP:   net.ParseIP("192.168.1.5"),
+			wantIPs:    defaultARecords["test001.t.example.com."],
+			wantPrefix: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ips, prefix := lookupARecords(tt.qname, tt.clientIP)
+			if prefix != tt.wantPrefix {
+				t.Errorf("scopePrefix = %d, want %d", prefix, tt.wantPrefix)
+			}
+			if len(ips) != len(tt.wantIPs) {
+				t.Fatalf("ips = %v, want %v", ips, tt.wantIPs)
+			}
+			for i := range ips {
+				if ips[i] != tt.wantIPs[i] {
+					t.Errorf("ips = %v, want %v", ips, tt.wantIPs)
+				}
+			}
+		})
+	}
+}
+
+func TestShuffleAnswersRandomizesOrder(t *testing.T) {
+	rrs := make([]dns.RR, 0, 20)
+	for i := 0; i < 20; i++ {
+		rrs = append(rrs, newRR(fmt.Sprintf("www.t.example.com. 5 IN A 192.168.0.%d", i+1)))
+	}
+	original := append([]dns.RR(nil), rrs...)
+
+	changed := false
+	for attempt := 0; attempt < 20 && !changed; attempt++ {
+		shuffleAnswers(rrs)
+		for i := range rrs {
+			if rrs[i] != original[i] {
+				changed = true
+				break
+			}
+		}
+	}
+	if !changed {
+		t.Fatal("shuffleAnswers never changed the order of answers across repeated attempts")
+	}
+}
+
+func TestShuffleAnswersKeepsTheSameElements(t *testing.T) {
+	a := newRR("t.example.com. 5 IN A 192.168.0.11")
+	b := newRR("t.example.com. 5 IN A 192.168.0.12")
+	c := newRR("t.example.com. 5 IN A 192.168.0.13")
+	rrs := []dns.RR{a, b, c}
+
+	shuffleAnswers(rrs)
+
+	seen := map[dns.RR]bool{}
+	for _, rr := range rrs {
+		seen[rr] = true
+	}
+	if len(seen) != 3 || !seen[a] || !seen[b] || !seen[c] {
+		t.Fatalf("shuffleAnswers changed the element set: %v", rrs)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	rrlRPSFlag        = flag.Float64("rrl-rps", 0, "responses-per-second allowed per (client subnet, response name, rcode class) bucket; 0 disables RRL")
+	rrlWindowFlag     = flag.Duration("rrl-window", time.Second, "token bucket refill window, combined with -rrl-rps to derive the burst size")
+	rrlSlipFlag       = flag.Float64("rrl-slip", 1, "probability (0-1) of sending a truncated TC=1 response instead of silently dropping once a bucket is empty")
+	rrlIPv4PrefixFlag = flag.Int("rrl-ipv4-prefix", 24, "IPv4 prefix length used to group clients into a single RRL bucket")
+	rrlIPv6PrefixFlag = flag.Int("rrl-ipv6-prefix", 56, "IPv6 prefix length used to group clients into a single RRL bucket")
+)
+
+// rrlKey はRRLのトークンバケツを(クライアントのサブネット, 応答名, rcodeクラス)単位で区別するためのキー
+type rrlKey struct {
+	subnet     string
+	name       string
+	rcodeClass string
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// rrlLimiter はBIND/KnotのRRL(Response Rate Limiting)と同様に、
+// (クライアントのサブネット, 応答名, rcodeクラス)単位のトークンバケツでレスポンス数を制限する
+type rrlLimiter struct {
+	mu      sync.Mutex
+	buckets map[rrlKey]*tokenBucket
+}
+
+var rrl = &rrlLimiter{buckets: make(map[rrlKey]*tokenBucket)}
+
+// rcodeClass はRRLがrcodeを粗く分類するためのクラス名を返す
+// NXDOMAIN、NODATA、正常な回答、エラーをそれぞれ別のバケツで数える
+func rcodeClass(rcode int, hasAnswer bool) string {
+	switch rcode {
+	case dns.RcodeNameError:
+		return "nxdomain"
+	case dns.RcodeSuccess:
+		if hasAnswer {
+			return "answer"
+		}
+		return "nodata"
+	default:
+		return "error"
+	}
+}
+
+// subnetKey はclientIPを設定済みのプレフィックス長(-rrl-ipv4-prefix/-rrl-ipv6-prefix)で丸めた文字列を返す
+func subnetKey(clientIP net.IP) string {
+	if clientIP == nil {
+		return ""
+	}
+	if ip4 := clientIP.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(*rrlIPv4PrefixFlag, 32)).String()
+	}
+	return clientIP.Mask(net.CIDRMask(*rrlIPv6PrefixFlag, 128)).String()
+}
+
+// allow はresponses-per-second(-rrl-rps)とburstに基づき、このレスポンスを送ってよいかを判定する
+// -rrl-rpsが0の場合はRRLを無効化し常にtrueを返す
+func (l *rrlLimiter) allow(clientIP net.IP, name, rcClass string) bool {
+	rps := *rrlRPSFlag
+	if rps <= 0 {
+		return true
+	}
+	burst := rps * rrlWindowFlag.Seconds()
+	// DNS名は大文字小文字を区別しないため、0x20エンコーディングでバケツを回避されないよう正規化する
+	key := rrlKey{subnet: subnetKey(clientIP), name: strings.ToLower(name), rcodeClass: rcClass}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: burst, lastRefill: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.lastRefill).Seconds() * rps
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.lastRefill = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// staleBucketMultiplier はバケツを未使用とみなして掃除するまでの猶予を-rrl-windowの何倍にするかを表す
+const staleBucketMultiplier = 10
+
+// startRRLCleanup は一定期間参照されていないトークンバケツを定期的に掃除するゴルーチンを開始する
+// これがないと、水責め攻撃で大量のユニークな名前が問い合わせられた際にbucketsが際限なく増え続けてしまう
+func startRRLCleanup() {
+	window := *rrlWindowFlag
+	if window <= 0 {
+		window = time.Second
+	}
+	interval := window * staleBucketMultiplier
+
+	go func() {
+		for range time.Tick(interval) {
+			rrl.sweep(interval)
+		}
+	}()
+}
+
+// sweep はstaleForより長く参照されていないバケツを取り除く
+func (l *rrlLimiter) sweep(staleFor time.Duration) {
+	cutoff := time.Now().Add(-staleFor)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if b.lastRefill.Before(cutoff) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// shouldSlip はRRLで制限されたレスポンスのうち、確率-rrl-slipでTC=1の切り詰め応答を返すかどうかを判定する
+// それ以外は完全に無応答にする(水責め対策と同じ挙動)
+func shouldSlip() bool {
+	return rand.Float64() < *rrlSlipFlag
+}
+
+// remoteIP はdns.ResponseWriterのRemoteAddr()からポートを除いたクライアントIPを取り出す
+func remoteIP(w dns.ResponseWriter) net.IP {
+	addr := w.RemoteAddr()
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// withRRLFlags temporarily overrides the RRL flags for the duration of a test.
+func withRRLFlags(t *testing.T, rps, slip float64, window time.Duration) {
+	t.Helper()
+	origRPS, origSlip, origWindow := *rrlRPSFlag, *rrlSlipFlag, *rrlWindowFlag
+	*rrlRPSFlag, *rrlSlipFlag, *rrlWindowFlag = rps, slip, window
+	t.Cleanup(func() {
+		*rrlRPSFlag, *rrlSlipFlag, *rrlWindowFlag = origRPS, origSlip, origWindow
+	})
+}
+
+func TestRRLAllowDisabledWhenRPSIsZero(t *testing.T) {
+	withRRLFlags(t, 0, 1, time.Second)
+	l := &rrlLimiter{buckets: make(map[rrlKey]*tokenBucket)}
+	clientIP := net.ParseIP("192.168.1.1")
+
+	for i := 0; i < 100; i++ {
+		if !l.allow(clientIP, "www.t.example.com.", "answer") {
+			t.Fatal("allow() returned false while RRL is disabled (-rrl-rps=0)")
+		}
+	}
+}
+
+func TestRRLAllowExhaustsBurstThenBlocks(t *testing.T) {
+	withRRLFlags(t, 1, 1, time.Second)
+	l := &rrlLimiter{buckets: make(map[rrlKey]*tokenBucket)}
+	clientIP := net.ParseIP("192.168.1.1")
+
+	if !l.allow(clientIP, "www.t.example.com.", "answer") {
+		t.Fatal("expected the first request within the burst to be allowed")
+	}
+	if l.allow(clientIP, "www.t.example.com.", "answer") {
+		t.Fatal("expected the bucket to be exhausted after consuming its single token")
+	}
+}
+
+func TestRRLAllowRefillsOverTime(t *testing.T) {
+	withRRLFlags(t, 1, 1, time.Second)
+	l := &rrlLimiter{buckets: make(map[rrlKey]*tokenBucket)}
+	clientIP := net.ParseIP("192.168.1.1")
+	key := rrlKey{subnet: subnetKey(clientIP), name: "www.t.example.com.", rcodeClass: "answer"}
+
+	if !l.allow(clientIP, "www.t.example.com.", "answer") {
+		t.Fatal("expected the first request to be allowed")
+	}
+	// simulate a full window having elapsed without waiting on a real clock
+	l.buckets[key].lastRefill = l.buckets[key].lastRefill.Add(-time.Second)
+
+	if !l.allow(clientIP, "www.t.example.com.", "answer") {
+		t.Fatal("expected the bucket to have refilled after a full window")
+	}
+}
+
+func TestRRLAllowBucketsAreCaseInsensitiveOnName(t *testing.T) {
+	withRRLFlags(t, 1, 1, time.Second)
+	l := &rrlLimiter{buckets: make(map[rrlKey]*tokenBucket)}
+	clientIP := net.ParseIP("192.168.1.1")
+
+	if !l.allow(clientIP, "WWW.t.example.com.", "answer") {
+		t.Fatal("expected the first request to be allowed")
+	}
+	if l.allow(clientIP, "www.t.example.com.", "answer") {
+		t.Fatal("0x20-randomized case should hit the same bucket as the lowercase name")
+	}
+}
+
+func TestRRLSweepRemovesOnlyStaleBuckets(t *testing.T) {
+	l := &rrlLimiter{buckets: make(map[rrlKey]*tokenBucket)}
+	fresh := rrlKey{subnet: "192.168.1.0", name: "fresh.t.example.com.", rcodeClass: "answer"}
+	stale := rrlKey{subnet: "192.168.1.0", name: "stale.t.example.com.", rcodeClass: "answer"}
+	l.buckets[fresh] = &tokenBucket{lastRefill: time.Now()}
+	l.buckets[stale] = &tokenBucket{lastRefill: time.Now().Add(-time.Hour)}
+
+	l.sweep(time.Minute)
+
+	if _, ok := l.buckets[stale]; ok {
+		t.Fatal("expected the stale bucket to be swept")
+	}
+	if _, ok := l.buckets[fresh]; !ok {
+		t.Fatal("expected the fresh bucket to survive the sweep")
+	}
+}
+
+func TestShouldSlipRespectsConfiguredProbability(t *testing.T) {
+	withRRLFlags(t, 1, 0, time.Second)
+	if shouldSlip() {
+		t.Fatal("expected shouldSlip() to always be false when -rrl-slip=0")
+	}
+
+	withRRLFlags(t, 1, 1, time.Second)
+	if !shouldSlip() {
+		t.Fatal("expected shouldSlip() to always be true when -rrl-slip=1")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"slices"
+
+	"github.com/miekg/dns"
+)
+
+// staticBackend はこれまでの挙動(subdomainsとdefaultARecords/subnetARecordsの組み合わせ)を
+// Backendインターフェースの形に包んだだけの実装
+type staticBackend struct{}
+
+func newStaticBackend() *staticBackend {
+	return &staticBackend{}
+}
+
+func (b *staticBackend) Lookup(qname string, qtype uint16) ([]dns.RR, error) {
+	rrs, _, err := b.LookupECS(qname, qtype, nil)
+	return rrs, err
+}
+
+func (b *staticBackend) LookupECS(qname string, qtype uint16, clientIP net.IP) ([]dns.RR, uint8, error) {
+	muSubdomains.RLock()
+	matched := slices.Contains(subdomains, qname)
+	muSubdomains.RUnlock()
+	if !matched {
+		return nil, 0, ErrNXDOMAIN
+	}
+	if qtype == dns.TypeNS {
+		if ns, ok := defaultNSRecords[qname]; ok {
+			return []dns.RR{newRR(qname + " 5 IN NS " + ns)}, 0, nil
+		}
+		return nil, 0, nil
+	}
+	if qtype != dns.TypeA {
+		return nil, 0, nil
+	}
+
+	ips, scopePrefix := lookupARecords(qname, clientIP)
+	rrs := make([]dns.RR, 0, len(ips))
+	for _, ip := range ips {
+		rrs = append(rrs, newRR(qname+" 5 IN A "+ip))
+	}
+	return rrs, scopePrefix, nil
+}
+
+func (b *staticBackend) SOA(qname string) dns.RR {
+	return newRR("t.example.com. 5 IN SOA ns1.t.example.com. admin.t.example.com. 1 7200 3600 1209600 5")
+}
+
+// AddName はnameをsubdomainsへ追加し、古いレスポンスが残らないようフォワードキャッシュを無効化する
+func (b *staticBackend) AddName(name string) error {
+	muSubdomains.Lock()
+	if !slices.Contains(subdomains, name) {
+		subdomains = append(append([]string(nil), subdomains...), name)
+	}
+	muSubdomains.Unlock()
+	fwdCache.purge()
+	return nil
+}
+
+// RemoveName はnameをsubdomainsから取り除き、古いレスポンスが残らないようフォワードキャッシュを無効化する
+func (b *staticBackend) RemoveName(name string) error {
+	muSubdomains.Lock()
+	subdomains = slices.DeleteFunc(append([]string(nil), subdomains...), func(s string) bool { return s == name })
+	muSubdomains.Unlock()
+	fwdCache.purge()
+	return nil
+}
+
+// ResetNames はsubdomainsをdefaultSubdomainsへ戻し、フォワードキャッシュを無効化する
+func (b *staticBackend) ResetNames() error {
+	resetSubdomains()
+	fwdCache.purge()
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	tcpAddrFlag = flag.String("tcp-addr", ":50053", "address to listen on for DNS over TCP (RFC 7766)")
+	tlsCertFlag = flag.String("tls-cert", "", "path to a TLS certificate, required to enable DNS-over-TLS and DNS-over-HTTPS")
+	tlsKeyFlag  = flag.String("tls-key", "", "path to the TLS certificate's private key")
+	dotAddrFlag = flag.String("dot-addr", ":50853", "address to listen on for DNS-over-TLS (RFC 7858)")
+	dohAddrFlag = flag.String("doh-addr", ":50854", "address to listen on for DNS-over-HTTPS (RFC 8484)")
+)
+
+// logQuery はトランスポートの種類ごとにクエリを構造化ログに記録する
+func logQuery(transport string, r *dns.Msg) {
+	if len(r.Question) == 0 {
+		return
+	}
+	q := r.Question[0]
+	log.Printf("transport=%s qname=%s qtype=%s", transport, q.Name, dns.TypeToString[q.Qtype])
+}
+
+// handlerFor はtransport名をログに残したうえでhandleへ処理を委譲するdns.HandlerFuncを返す
+func handlerFor(transport string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		logQuery(transport, r)
+		handle(w, r)
+	}
+}
+
+// serveTCP はRFC 7766で求められるDNS over TCPのリスナーを開始する
+func serveTCP(addr string) *dns.Server {
+	server := &dns.Server{Addr: addr, Net: "tcp", Handler: handlerFor("tcp")}
+	go serveDNS(server)
+	return server
+}
+
+// serveDoT はcert/keyを使ったDNS-over-TLS (RFC 7858) のリスナーを開始する
+// cert/keyが指定されていない場合は何もせずnilを返す
+func serveDoT(addr, certFile, keyFile string) *dns.Server {
+	if certFile == "" || keyFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatal("Failed to load DoT certificate: ", err)
+	}
+	server := &dns.Server{
+		Addr:      addr,
+		Net:       "tcp-tls",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Handler:   handlerFor("dot"),
+	}
+	go serveDNS(server)
+	return server
+}
+
+// serveDoH はapplication/dns-messageのPOSTボディと?dns=base64urlのGETクエリの両方を受け付ける
+// DNS-over-HTTPS (RFC 8484) のリスナーを開始する
+// cert/keyが指定されていない場合は何もせずnilを返す
+func serveDoH(addr, certFile, keyFile string) *http.Server {
+	if certFile == "" || keyFile == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", handleDoH)
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start DoH server: ", err)
+		}
+	}()
+	return server
+}
+
+// handleDoH はDoHリクエストをデコードし、UDP/TCPと同じhandleへ処理を委譲する
+func handleDoH(w http.ResponseWriter, req *http.Request) {
+	var msgData []byte
+	var err error
+
+	switch req.Method {
+	case http.MethodPost:
+		if req.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		msgData, err = io.ReadAll(io.LimitReader(req.Body, 65535))
+	case http.MethodGet:
+		msgData, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	m := new(dns.Msg)
+	if err := m.Unpack(msgData); err != nil {
+		http.Error(w, "invalid dns message", http.StatusBadRequest)
+		return
+	}
+	logQuery("doh", m)
+
+	dw := newDoHResponseWriter(req)
+	handle(dw, m)
+	if dw.msg == nil {
+		// handle()が水責め対策などで応答しなかった場合でも、HTTPリクエストには何かしら返す必要がある
+		http.Error(w, "no response", http.StatusGatewayTimeout)
+		return
+	}
+	packed, err := dw.msg.Pack()
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(packed)
+}
+
+// doHResponseWriter はdns.ResponseWriterをHTTPのリクエスト/レスポンスの上に被せるための最小限の実装
+type doHResponseWriter struct {
+	req *http.Request
+	msg *dns.Msg
+}
+
+func newDoHResponseWriter(req *http.Request) *doHResponseWriter {
+	return &doHResponseWriter{req: req}
+}
+
+func (w *doHResponseWriter) LocalAddr() net.Addr  { return dohAddr{} }
+func (w *doHResponseWriter) RemoteAddr() net.Addr { return dohAddr{addr: w.req.RemoteAddr} }
+func (w *doHResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+func (w *doHResponseWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+func (w *doHResponseWriter) Close() error        { return nil }
+func (w *doHResponseWriter) TsigStatus() error   { return nil }
+func (w *doHResponseWriter) TsigTimersOnly(bool) {}
+func (w *doHResponseWriter) Hijack()             {}
+
+// dohAddr はDoHのHTTPリクエストにはnet.Connが存在しないため、net.Addrを満たすためだけのダミー
+type dohAddr struct{ addr string }
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return a.addr }
+
+// shutdownTimeout はSIGTERM受信後、HTTPリスナー(DoH/管理API)をgraceful shutdownするまでの猶予時間
+// DNSのUDP/TCP/DoTリスナーのShutdown()にはこのタイムアウトは適用されない
+const shutdownTimeout = 5 * time.Second
+
+// shutdownHTTP はhttp.ServerをshutdownTimeout内にgraceful shutdownする
+func shutdownHTTP(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down HTTP server: %v", err)
+	}
+}